@@ -1,17 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 //辅助函数：尝试连接一个地址，返回是否存活
@@ -34,14 +37,18 @@ func isAlive(u *url.URL)bool{
 }
 
 type Config struct{
-	Port 	 string 	`json:"port"`
-	Backends []string	`json:"backends"`
-}
-
-type Backend struct{
-	URL 	*url.URL
-	Alive 	bool
-	ReverseProxy *httputil.ReverseProxy	//存好代理对象，不用每次 new
+	Port 	 string 	      `json:"port"`
+	Strategy string	      `json:"strategy"` // "round-robin" / "weighted-round-robin" / "least-connections"
+	Backends []BackendConfig `json:"backends"`
+	MaxAttempts int		  `json:"maxAttempts"` // 单个节点最多转发几次（含第一次）才放弃，默认 1（失败一次就不再打这个节点）
+	MaxRetries  int		  `json:"maxRetries"`  // 总共最多换几个节点重试，默认 0（不重试）
+	Discovery DiscoveryConfig `json:"discovery"` // 服务发现配置，不填就用 Backends 里写死的静态列表
+	HealthCheck HealthCheckConfig `json:"healthCheck"` // 主动健康检查配置，不填就是老的 TCP 探活
+	DrainTimeoutSeconds int `json:"drainTimeoutSeconds"` // 节点下线/手动摘除时，最多等它 ActiveConns 归零多久，默认 30
+	CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker"` // 每个节点独立的熔断器配置
+	MaxConns    int             `json:"maxConns"`    // 单个节点默认的最大并发数，<=0 表示不限制，可被 backend 里的 maxConns 覆盖
+	RateLimit   RateLimitConfig `json:"rateLimit"`   // 按客户端 IP 的全局令牌桶限流，RPS<=0 表示不限流
+	ShutdownGraceSeconds int    `json:"shutdownGraceSeconds"` // 收到退出信号后最多等多久排空连接，默认 15
 }
 
 // 读取配置文件
@@ -75,119 +82,152 @@ func main(){
 		panic(err)
 	}
 
-	// 1.1定义一个切片，用来存放所有的后端对象
-	var nodes []*Backend
-	// 1.2遍历配置文件里的 IP 列表
-	for _,ip:=range config.Backends{
+	// 结构化日志：所有请求路径上的日志都打 JSON，方便以后接日志系统
+	logger,err=zap.NewProduction()
+	if err!=nil{
+		panic(err)
+	}
+	defer logger.Sync()
 
-		// A. 解析 URL
-		u,err:=url.Parse(ip)
-		if err!=nil{
-			fmt.Printf("解析 URL 失败: %s\n", ip)
-            continue // 跳过这个错误的 IP
-		}
+	// 重试相关的默认值：不配置的话，就是"失败一次就放弃这个节点，也不跨节点重试，直接 502"
+	if config.MaxAttempts<=0{
+		config.MaxAttempts=1
+	}
 
-		// B. 创建反向代理 (Proxy)
-		proxy:=httputil.NewSingleHostReverseProxy(u)
+	// 1.1 节点不再是写死的切片，而是一个可以被服务发现动态增删的 ServerPool
+	pool:=NewServerPool()
 
-		// C. 设置 Director
-		proxy.Director=func(req *http.Request){
-			req.Host=u.Host
-			req.URL.Host=u.Host
-			req.URL.Scheme=u.Scheme
-		}
+	// cfgStore 把 *Config 包一层原子指针：SIGHUP 重新加载配置时整个换一份新的
+	// *Config 上去，而不是对同一个 *Config 做字段级原地拷贝（那样会跟请求路径上
+	// 并发读取 cfg.XXX 的 goroutine 打架），所有需要读"当前配置"的地方都用
+	// cfgStore.Load() 现读，不要长期攥着某一次 Load() 的结果
+	cfgStore:=NewConfigStore(config)
 
-		// D. 设置 ErrorHandler (错误处理)
-		proxy.ErrorHandler=func(w http.ResponseWriter, r *http.Request, err error) {
-			fmt.Printf(" [LB错误] 转发失败: %v\n", err)
-			w.WriteHeader(http.StatusBadGateway)
-			w.Write([]byte("服务器挂了，正在抢救..."))
-		}
+	// 1.2 balancer 只依赖 pool，不依赖具体有哪些节点，所以可以先造出来
+	// 包一层 holder 是为了让 SIGHUP 热重载时能直接换掉正在用的 Balancer 实现，
+	// 而不用重建已经发下去的闭包（健康检查、ErrorHandler、路由 handler 都只认 holder）
+	holder:=NewBalancerHolder(NewBalancer(config.Strategy,pool))
 
-		// E. 组装成 Backend 对象
-		node:=&Backend{
-			URL: u,
-			Alive: true,
-			ReverseProxy: proxy,
-		}
+	// 1.3 factory 把"造一个 Backend"（解析 URL、装 Director、接 ErrorHandler）的逻辑
+	// 统一收口，静态配置和各种服务发现都调用它来生产节点
+	factory:=newBackendFactory(holder,pool,cfgStore)
 
-		// F. 放入列表
-		nodes=append(nodes, node)
+	// 1.4 按配置选一个服务发现实现，把节点同步进 pool。
+	// Type 为空/"static" 时就是老行为：直接用 config.json 里写死的 backends 列表
+	discovery:=newDiscovery(config)
+	if err:=discovery.Run(pool,factory);err!=nil{
+		panic(err)
 	}
 
-	// 启动健康检查协程
+	// 启动健康检查：每个节点各自一个协程，互不阻塞（相比以前串行挨个检查，节点多了也不会拖慢探测周期）
+	checker:=NewHealthChecker(config.HealthCheck)
+	checker.Watch(pool)
+
+	// 收到 SIGHUP 就重新读一遍 config.json，热加载节点列表/权重/策略，不用重启进程
+	reloadSignal:=make(chan os.Signal,1)
+	signal.Notify(reloadSignal,syscall.SIGHUP)
 	go func(){
-		for {// 无限循环
-			for _,node:=range nodes{
-				// 检查死活
-				alive:=isAlive(node.URL)
-
-				// 关键：只有状态变了才打印日志 & 更新状态
-                // 如果以前是活的(true)，现在死了(false) -> 报错
-                // 如果以前是死的(false)，现在活了(true) -> 庆祝
-				if node.Alive!=alive{
-					node.Alive=alive
-					if alive{
-						fmt.Printf(" [健康检查] %s 复活了! \n", node.URL.Host)
-					}else {
-						fmt.Printf(" [健康检查] %s 挂了! \n", node.URL.Host)
-					}
-				}
+		for range reloadSignal{
+			logger.Info("热重载：收到 SIGHUP，开始重新加载 config.json")
+			if err:=reloadConfig("config.json",cfgStore,pool,factory,holder);err!=nil{
+				logger.Warn("热重载：失败", zap.Error(err))
 			}
-
-            // 检查完一轮后，要休息多久？
-			time.Sleep(2*time.Second)
 		}
 	}()
-	
-	r:=gin.Default()
 
-	// 2.全局计数器
-	var requestCounter uint64=0
+	r:=gin.Default()
+	r.Use(requestLoggingMiddleware())
+	r.Use(rateLimitMiddleware(config.RateLimit))
+
+	// 2.运维接口：查看每个节点的健康状态 / 手动把某个节点摘下去排空 / Prometheus 指标
+	r.GET("/metrics", gin.WrapH(metricsHandler()))
+	r.GET("/lb/status", func(c *gin.Context) {
+		nodes:=pool.All()
+		snapshots:=make([]healthSnapshot,0,len(nodes))
+		for _,node:=range nodes{
+			snapshots=append(snapshots,node.snapshot())
+		}
+		c.JSON(http.StatusOK,snapshots)
+	})
+
+	r.POST("/lb/drain", func(c *gin.Context) {
+		rawURL:=c.Query("url")
+		u,err:=url.Parse(rawURL)
+		if err!=nil || rawURL==""{
+			c.String(http.StatusBadRequest,"缺少合法的 url 参数")
+			return
+		}
+		pool.Remove(u,drainTimeout(cfgStore.Load()))
+		c.String(http.StatusOK,"节点 %s 已开始排空",u.Host)
+	})
+
+	// 手动把某个节点标记成 alive/dead，用于运维明确知道某个节点状态跟健康检查判断
+	// 不一致时的人工干预（比如提前把一个即将下线的节点标脏，不用等探活周期反应过来）
+	r.POST("/lb/mark", func(c *gin.Context) {
+		rawURL:=c.Query("url")
+		u,err:=url.Parse(rawURL)
+		if err!=nil || rawURL==""{
+			c.String(http.StatusBadRequest,"缺少合法的 url 参数")
+			return
+		}
+		alive,err:=strconv.ParseBool(c.Query("alive"))
+		if err!=nil{
+			c.String(http.StatusBadRequest,"alive 参数必须是 true/false")
+			return
+		}
+		pool.MarkStatus(u,alive)
+		c.String(http.StatusOK,"节点 %s 已标记为 alive=%v",u.Host,alive)
+	})
 
 	// 3.创建路由
 	r.Any("/*path", func(c *gin.Context) {
-        // 拦截 Favicon 
+        // 拦截 Favicon
         if c.Request.URL.Path == "/favicon.ico" {
             c.AbortWithStatus(204)
             return
         }
 
-        // 定义一个变量，用来装最终选中的那个“活”节点
-        var targetNode *Backend = nil
-    
-        for i := 0; i < len(nodes); i++ {
-            
-            // 轮询算法 (要在循环里算!)
-            current:=atomic.AddUint64(&requestCounter,1)
-			index:=current%uint64(len(nodes))
-
-            // 取出候选人
-            candidate:=nodes[index]
-
-            // 如果 candidate.Alive 是 true：
-            // 1. 把它赋值给 targetNode
-            // 2. 打印日志
-            // 3. break 
-			if candidate.Alive==true{
-				targetNode=candidate
-				fmt.Printf("请求 #%d -> 转发给: %s\n",current,candidate.URL.Host)
-				break
-			}
-            
-        }
+        // 把请求体缓存好、重试状态塞进 context，这样转发失败时 ErrorHandler 才能重放请求
+		c.Request=prepareRetry(c.Request)
 
-        // 说明所有节点都挂了！
+        // 交给 balancer 挑节点，不同策略的选择逻辑都封装在 NextPeer 里了
+		targetNode:=holder.NextPeer(c.Request)
+
+        // 说明没有节点能接这个请求了，再区分一下是真没节点还是被熔断器短路了
         if targetNode == nil {
-            // 返回 502 错误
-			c.String(502,"服务器全军覆没")
+            if allBreakerOpen(pool.Peers()) {
+                c.String(http.StatusServiceUnavailable,"后端暂时被熔断，请稍后重试")
+            } else {
+                c.String(http.StatusBadGateway,"服务器全军覆没")
+            }
             return
         }
 
+		c.Set(ctxKeyBackend,targetNode.URL.Host)
+
+        // 转发前 +1，转发完（不管成功失败）都要 -1，这样 ActiveConns 才能被最小连接数算法用上
+		atomic.AddInt64(&targetNode.ActiveConns,1)
+		activeConnections.WithLabelValues(targetNode.URL.Host).Inc()
+		defer func(){
+			atomic.AddInt64(&targetNode.ActiveConns,-1)
+			activeConnections.WithLabelValues(targetNode.URL.Host).Dec()
+		}()
+
         //正式启动
 		targetNode.ReverseProxy.ServeHTTP(c.Writer,c.Request)
-        
+
     })
 
-	r.Run(config.Port)
+	// 用 http.Server 包一层而不是 r.Run，这样退出信号来了之后才能调用 Shutdown 优雅关机，
+	// 而不是直接把还在处理的请求连带进程一起干掉
+	srv:=&http.Server{Addr:config.Port,Handler:r}
+	go func(){
+		if err:=srv.ListenAndServe();err!=nil && err!=http.ErrServerClosed{
+			panic(err)
+		}
+	}()
+
+	ctx,cancel:=signal.NotifyContext(context.Background(),os.Interrupt,syscall.SIGTERM)
+	defer cancel()
+	gracefulShutdown(ctx,srv,pool,cfgStore.Load().ShutdownGraceSeconds)
 }
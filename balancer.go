@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// Backend 代表一个后端服务节点
+type Backend struct {
+	URL          *url.URL
+	Alive        atomic.Bool // 存活状态，recordCheck/MarkStatus 并发写、balancer 并发读，原子读写，原因同 Weight
+	Draining     bool  // 正在被服务发现摘除，不再接收新请求，但还要等 ActiveConns 归零
+	Weight       atomic.Int32 // 权重，值越大被选中的概率越高，默认为 1；SIGHUP 热重载会并发改写，原子读写
+	CurrentWeight int64 // 平滑加权轮询算法用的"当前权重"，只在 balancer 内部读写
+	ActiveConns  int64 // 当前正在处理的请求数，原子计数，用于最小连接数算法
+	MaxConns     atomic.Int32 // 最大并发数，<=0 表示不限制，见 availableBackends；原因同 Weight
+	ReverseProxy *httputil.ReverseProxy
+	health       healthState      // 主动健康检查的连续成功/失败计数，见 health.go
+	Breaker      *CircuitBreaker  // 每个节点独立的熔断器，见 breaker.go
+}
+
+// BackendConfig 对应 config.json 里 backends 数组的一项
+// 既可以写成一个普通字符串 "http://127.0.0.1:8081"
+// 也可以写成 {"url": "http://127.0.0.1:8081", "weight": 5}
+type BackendConfig struct {
+	URL      string `json:"url"`
+	Weight   int    `json:"weight"`
+	MaxConns int    `json:"maxConns"` // 覆盖 Config.MaxConns，0 表示跟随全局配置
+}
+
+// UnmarshalJSON 兼容"纯字符串"和"带权重的对象"两种写法
+func (b *BackendConfig) UnmarshalJSON(data []byte) error {
+	// 先按纯字符串尝试解析
+	var plain string
+	if err := json.Unmarshal(data, &plain); err == nil {
+		b.URL = plain
+		b.Weight = 1
+		return nil
+	}
+
+	// 不是字符串，那就按对象解析
+	type alias BackendConfig // 用别名防止递归调用 UnmarshalJSON
+	var obj alias
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	if obj.Weight <= 0 {
+		obj.Weight = 1 // 没填权重或填了非法值，兜底为 1
+	}
+	*b = BackendConfig(obj)
+	return nil
+}
+
+// Balancer 是所有负载均衡策略的统一接口
+// 不同的算法（轮询、加权轮询、最小连接数...）只需要实现 NextPeer
+type Balancer interface {
+	// NextPeer 从 backends 里选出下一个应该转发的节点
+	// 如果没有存活节点，返回 nil
+	NextPeer(req *http.Request) *Backend
+}
+
+// availableBackends 过滤出"现在能接这个请求"的节点：必须存活、熔断器没跳闸（或者
+// 处于半开状态但已经没有在飞的探测请求）、并且并发数没有打满 MaxConns
+func availableBackends(backends []*Backend) []*Backend {
+	available := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		if !b.Alive.Load() {
+			continue
+		}
+		conns := atomic.LoadInt64(&b.ActiveConns)
+		if b.Breaker != nil && !b.Breaker.Allow(conns) {
+			continue
+		}
+		if maxConns := b.MaxConns.Load(); maxConns > 0 && conns >= int64(maxConns) {
+			continue
+		}
+		available = append(available, b)
+	}
+	return available
+}
+
+// allBreakerOpen 区分"真的一个节点都不能用了"(502) 和"节点都还活着，只是被熔断器
+// 短路掉了"(503) 这两种情况：只要还有一个存活节点的熔断器处于 closed 状态，就不算全部熔断。
+func allBreakerOpen(backends []*Backend) bool {
+	aliveCount := 0
+	for _, b := range backends {
+		if !b.Alive.Load() {
+			continue
+		}
+		aliveCount++
+		if b.Breaker == nil || atomic.LoadInt32(&b.Breaker.state) == breakerClosed {
+			return false
+		}
+	}
+	return aliveCount > 0
+}
+
+// ---------- 1. 普通轮询 (Round Robin) ----------
+
+// RoundRobinBalancer 按顺序依次选择存活节点，等价于原来 main() 里的逻辑。
+// 节点从 pool 里实时读取，所以服务发现增删节点时不需要重建 balancer。
+type RoundRobinBalancer struct {
+	pool    *ServerPool
+	counter uint64
+}
+
+func NewRoundRobinBalancer(pool *ServerPool) *RoundRobinBalancer {
+	return &RoundRobinBalancer{pool: pool}
+}
+
+func (r *RoundRobinBalancer) NextPeer(req *http.Request) *Backend {
+	alive := availableBackends(r.pool.Peers())
+	if len(alive) == 0 {
+		return nil
+	}
+	idx := atomic.AddUint64(&r.counter, 1) % uint64(len(alive))
+	return alive[idx]
+}
+
+// ---------- 2. 平滑加权轮询 (Smooth Weighted Round Robin) ----------
+
+// WeightedRoundRobinBalancer 实现了 Nginx 同款的平滑加权轮询算法：
+// 每次挑选时，所有节点的 current_weight 先加上自己的 weight，
+// 然后选出 current_weight 最大的那个，再把它的 current_weight 减去
+// 所有节点 weight 的总和。这样可以让高权重节点均匀地穿插在请求序列里，
+// 而不是像"先攒够优先级"那样连续命中同一个节点（AAABBC 这种扎堆模式）。
+type WeightedRoundRobinBalancer struct {
+	mu   sync.Mutex
+	pool *ServerPool
+}
+
+func NewWeightedRoundRobinBalancer(pool *ServerPool) *WeightedRoundRobinBalancer {
+	return &WeightedRoundRobinBalancer{pool: pool}
+}
+
+func (w *WeightedRoundRobinBalancer) NextPeer(req *http.Request) *Backend {
+	alive := availableBackends(w.pool.Peers())
+	if len(alive) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var best *Backend
+	totalWeight := 0
+	for _, b := range alive {
+		weight := int(b.Weight.Load())
+		if weight <= 0 {
+			weight = 1
+		}
+		totalWeight += weight
+
+		b.CurrentWeight += int64(weight)
+		if best == nil || b.CurrentWeight > best.CurrentWeight {
+			best = b
+		}
+	}
+
+	best.CurrentWeight -= int64(totalWeight)
+	return best
+}
+
+// ---------- 3. 最小连接数 (Least Connections) ----------
+
+// LeastConnectionsBalancer 每次都挑选当前 ActiveConns 最小的存活节点，
+// 遇到连接数相同时按权重更高的优先（权重相同则维持遍历顺序，谁先扫到选谁）。
+type LeastConnectionsBalancer struct {
+	pool *ServerPool
+}
+
+func NewLeastConnectionsBalancer(pool *ServerPool) *LeastConnectionsBalancer {
+	return &LeastConnectionsBalancer{pool: pool}
+}
+
+func (l *LeastConnectionsBalancer) NextPeer(req *http.Request) *Backend {
+	alive := availableBackends(l.pool.Peers())
+	if len(alive) == 0 {
+		return nil
+	}
+
+	best := alive[0]
+	for _, b := range alive[1:] {
+		bConns := atomic.LoadInt64(&b.ActiveConns)
+		bestConns := atomic.LoadInt64(&best.ActiveConns)
+		switch {
+		case bConns < bestConns:
+			best = b
+		case bConns == bestConns && b.Weight.Load() > best.Weight.Load():
+			best = b
+		}
+	}
+	return best
+}
+
+// NewBalancer 根据配置里的 strategy 字段构造对应的 Balancer 实现
+// 未识别的策略名一律退化为普通轮询，保证老配置文件无需修改也能跑起来
+func NewBalancer(strategy string, pool *ServerPool) Balancer {
+	switch strategy {
+	case "weighted-round-robin":
+		return NewWeightedRoundRobinBalancer(pool)
+	case "least-connections":
+		return NewLeastConnectionsBalancer(pool)
+	case "round-robin", "":
+		return NewRoundRobinBalancer(pool)
+	default:
+		return NewRoundRobinBalancer(pool)
+	}
+}
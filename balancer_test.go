@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// newTestBackend 造一个最简单的、可以直接塞进 ServerPool 的测试节点：
+// 不装 ReverseProxy/Breaker，balancer 不会用到这些字段
+func newTestBackend(t *testing.T, rawURL string, weight int) *Backend {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("解析测试用 URL 失败: %v", err)
+	}
+	b := &Backend{URL: u}
+	b.Alive.Store(true)
+	b.Weight.Store(int32(weight))
+	return b
+}
+
+func TestRoundRobinBalancerCyclesEvenly(t *testing.T) {
+	pool := NewServerPool()
+	pool.Add(newTestBackend(t, "http://a", 1))
+	pool.Add(newTestBackend(t, "http://b", 1))
+	pool.Add(newTestBackend(t, "http://c", 1))
+
+	rr := NewRoundRobinBalancer(pool)
+	counts := map[string]int{}
+	for i := 0; i < 9; i++ {
+		peer := rr.NextPeer(nil)
+		if peer == nil {
+			t.Fatalf("第 %d 次选择不应该返回 nil", i)
+		}
+		counts[peer.URL.Host]++
+	}
+
+	for host, c := range counts {
+		if c != 3 {
+			t.Errorf("节点 %s 被选中 %d 次，期望轮询均匀分到 3 次", host, c)
+		}
+	}
+}
+
+func TestRoundRobinBalancerNoAliveReturnsNil(t *testing.T) {
+	pool := NewServerPool()
+	b := newTestBackend(t, "http://a", 1)
+	b.Alive.Store(false)
+	pool.Add(b)
+
+	rr := NewRoundRobinBalancer(pool)
+	if peer := rr.NextPeer(nil); peer != nil {
+		t.Fatalf("没有存活节点时应该返回 nil，实际返回了 %v", peer.URL)
+	}
+}
+
+func TestWeightedRoundRobinBalancerRespectsWeights(t *testing.T) {
+	pool := NewServerPool()
+	pool.Add(newTestBackend(t, "http://heavy", 3))
+	pool.Add(newTestBackend(t, "http://light", 1))
+
+	wrr := NewWeightedRoundRobinBalancer(pool)
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		peer := wrr.NextPeer(nil)
+		counts[peer.URL.Host]++
+	}
+
+	if counts["heavy"] != 6 || counts["light"] != 2 {
+		t.Fatalf("8 次里权重 3:1 应该分成 6:2，实际是 heavy=%d light=%d", counts["heavy"], counts["light"])
+	}
+}
+
+func TestWeightedRoundRobinBalancerDoesNotStarveLightNode(t *testing.T) {
+	// 平滑加权轮询的关键特性：高权重节点不应该连续命中好几次再轮到低权重节点，
+	// 而是穿插着选。这里只断言"不会出现连续 3 次以上都是同一个节点"这种扎堆模式。
+	// 权重比例用 2:1（而不是 3:1）：3:1 时高权重节点天然要占 8 次里的 6 次，
+	// 一个周期内部就得有一次连续 3 次命中，不是算法的缺陷，断言 2 次封顶才有意义。
+	pool := NewServerPool()
+	pool.Add(newTestBackend(t, "http://heavy", 2))
+	pool.Add(newTestBackend(t, "http://light", 1))
+
+	wrr := NewWeightedRoundRobinBalancer(pool)
+	streak, maxStreak, last := 0, 0, ""
+	for i := 0; i < 12; i++ {
+		peer := wrr.NextPeer(nil)
+		if peer.URL.Host == last {
+			streak++
+		} else {
+			streak = 1
+			last = peer.URL.Host
+		}
+		if streak > maxStreak {
+			maxStreak = streak
+		}
+	}
+	if maxStreak > 2 {
+		t.Fatalf("同一个节点连续被选中了 %d 次，平滑加权轮询不应该扎堆", maxStreak)
+	}
+}
+
+func TestLeastConnectionsBalancerPicksFewestConns(t *testing.T) {
+	pool := NewServerPool()
+	busy := newTestBackend(t, "http://busy", 1)
+	busy.ActiveConns = 5
+	idle := newTestBackend(t, "http://idle", 1)
+	pool.Add(busy)
+	pool.Add(idle)
+
+	lc := NewLeastConnectionsBalancer(pool)
+	peer := lc.NextPeer(nil)
+	if peer.URL.Host != "idle" {
+		t.Fatalf("期望选中连接数更少的 idle，实际选了 %s", peer.URL.Host)
+	}
+}
+
+func TestLeastConnectionsBalancerTieBreaksByWeight(t *testing.T) {
+	pool := NewServerPool()
+	pool.Add(newTestBackend(t, "http://low", 1))
+	pool.Add(newTestBackend(t, "http://high", 5))
+
+	lc := NewLeastConnectionsBalancer(pool)
+	peer := lc.NextPeer(nil)
+	if peer.URL.Host != "high" {
+		t.Fatalf("连接数相同时应该优先选权重更高的 high，实际选了 %s", peer.URL.Host)
+	}
+}
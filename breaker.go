@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 三态熔断器：closed（正常）/ open（熔断，直接拒绝）/ half-open（试探性放一个请求过去看看）
+const (
+	breakerClosed int32 = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreakerConfig 对应 config.json 里 "circuitBreaker" 字段
+type CircuitBreakerConfig struct {
+	ErrorRatio      float64 `json:"errorRatio"`      // 统计窗口里错误占比达到这个值就跳闸，默认 0.5；窗口是固定窗口，每过 WindowSeconds 整体清零，不是逐秒滑动
+	MinRequests     int     `json:"minRequests"`     // 窗口里至少要有这么多请求才评估错误率，避免样本太少误判，默认 10
+	WindowSeconds   int     `json:"windowSeconds"`   // 统计窗口长度，默认 10
+	OpenTimeoutSeconds int `json:"openTimeoutSeconds"` // 熔断多久之后放一个探测请求过去，默认 10
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.ErrorRatio <= 0 {
+		c.ErrorRatio = 0.5
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 10
+	}
+	if c.WindowSeconds <= 0 {
+		c.WindowSeconds = 10
+	}
+	if c.OpenTimeoutSeconds <= 0 {
+		c.OpenTimeoutSeconds = 10
+	}
+	return c
+}
+
+// CircuitBreaker 是挂在每个 Backend 上的独立熔断器
+type CircuitBreaker struct {
+	cfg CircuitBreakerConfig
+
+	state int32 // 原子读写，取值见上面的 breakerXxx 常量
+
+	mu          sync.Mutex
+	windowStart time.Time
+	total       int
+	failures    int
+	openedAt    time.Time
+}
+
+func NewCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		cfg:         cfg.withDefaults(),
+		windowStart: time.Time{}, // 零值，第一次 Record 时会自动重置窗口
+	}
+}
+
+// Allow 判断当前要不要把请求放给这个节点。
+// half-open 状态下借用 ActiveConns 当"有没有探测请求在飞"的信号：
+// 等它真的降回 0 才放下一个探测请求过去，这样不用额外再造一套"谁在探测"的状态。
+func (cb *CircuitBreaker) Allow(activeConns int64) bool {
+	switch atomic.LoadInt32(&cb.state) {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		cb.mu.Lock()
+		elapsed := time.Since(cb.openedAt) >= time.Duration(cb.cfg.OpenTimeoutSeconds)*time.Second
+		cb.mu.Unlock()
+		if !elapsed {
+			return false
+		}
+		atomic.CompareAndSwapInt32(&cb.state, breakerOpen, breakerHalfOpen)
+		return activeConns == 0
+	default: // breakerHalfOpen
+		return activeConns == 0
+	}
+}
+
+// RecordSuccess/RecordFailure 在每次转发结束之后调用（ErrorHandler 算失败，
+// ModifyResponse 看到 5xx 也算失败），用来更新错误率窗口、驱动三态之间的转换。
+func (cb *CircuitBreaker) RecordSuccess() {
+	if atomic.LoadInt32(&cb.state) == breakerHalfOpen {
+		atomic.StoreInt32(&cb.state, breakerClosed)
+	}
+	cb.record(false)
+}
+
+func (cb *CircuitBreaker) RecordFailure() {
+	if atomic.LoadInt32(&cb.state) == breakerHalfOpen {
+		cb.trip()
+		return
+	}
+	cb.record(true)
+}
+
+func (cb *CircuitBreaker) record(failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if time.Since(cb.windowStart) > time.Duration(cb.cfg.WindowSeconds)*time.Second {
+		cb.windowStart = time.Now()
+		cb.total = 0
+		cb.failures = 0
+	}
+
+	cb.total++
+	if failed {
+		cb.failures++
+	}
+
+	if atomic.LoadInt32(&cb.state) == breakerClosed &&
+		cb.total >= cb.cfg.MinRequests &&
+		float64(cb.failures)/float64(cb.total) >= cb.cfg.ErrorRatio {
+		cb.openLocked()
+	}
+}
+
+func (cb *CircuitBreaker) trip() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.openLocked()
+}
+
+// openLocked 假定调用方已经持有 cb.mu
+func (cb *CircuitBreaker) openLocked() {
+	cb.openedAt = time.Now()
+	cb.total = 0
+	cb.failures = 0
+	atomic.StoreInt32(&cb.state, breakerOpen)
+}
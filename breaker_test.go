@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTripsOnErrorRatio(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{
+		ErrorRatio:  0.5,
+		MinRequests: 4,
+		WindowSeconds: 60,
+	})
+
+	if !cb.Allow(0) {
+		t.Fatalf("初始状态应该是 closed，放行请求")
+	}
+
+	// 4 次里 2 次失败，刚好摸到 ErrorRatio=0.5 的门槛
+	cb.RecordSuccess()
+	cb.RecordFailure()
+	cb.RecordSuccess()
+	cb.RecordFailure()
+
+	if cb.Allow(0) {
+		t.Fatalf("错误率达到阈值之后应该跳闸，拒绝请求")
+	}
+}
+
+func TestCircuitBreakerStaysClosedBelowMinRequests(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{
+		ErrorRatio:  0.1,
+		MinRequests: 10,
+		WindowSeconds: 60,
+	})
+
+	// 样本数不够 MinRequests，哪怕全失败也不该跳闸
+	cb.RecordFailure()
+	cb.RecordFailure()
+	cb.RecordFailure()
+
+	if !cb.Allow(0) {
+		t.Fatalf("样本数还没到 MinRequests 时不应该跳闸")
+	}
+}
+
+func TestCircuitBreakerHalfOpenAfterTimeout(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{
+		ErrorRatio:      0.5,
+		MinRequests:     1,
+		WindowSeconds:   60,
+		OpenTimeoutSeconds: 1,
+	})
+
+	cb.RecordFailure() // 1 次请求，100% 失败，直接跳闸
+	if cb.Allow(0) {
+		t.Fatalf("刚跳闸应该处于 open，拒绝请求")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	// 超时之后进入 half-open，只有 activeConns==0（没有探测请求在飞）才放行
+	if !cb.Allow(0) {
+		t.Fatalf("open 超时之后、没有在飞的探测请求时应该放行一个探测请求")
+	}
+	if cb.Allow(5) {
+		t.Fatalf("half-open 状态下已经有探测请求在飞（activeConns>0）不应该再放行")
+	}
+}
+
+func TestCircuitBreakerHalfOpenSuccessCloses(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{
+		ErrorRatio:      0.5,
+		MinRequests:     1,
+		WindowSeconds:   60,
+		OpenTimeoutSeconds: 1,
+	})
+
+	cb.RecordFailure()
+	time.Sleep(1100 * time.Millisecond)
+	cb.Allow(0) // 触发 open -> half-open 的转换
+
+	cb.RecordSuccess()
+	if !cb.Allow(0) {
+		t.Fatalf("half-open 探测成功之后应该回到 closed，正常放行")
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{
+		ErrorRatio:      0.5,
+		MinRequests:     1,
+		WindowSeconds:   60,
+		OpenTimeoutSeconds: 1,
+	})
+
+	cb.RecordFailure()
+	time.Sleep(1100 * time.Millisecond)
+	cb.Allow(0) // 触发 open -> half-open 的转换
+
+	cb.RecordFailure() // 探测失败，应该立刻重新跳闸
+	if cb.Allow(0) {
+		t.Fatalf("half-open 探测失败之后应该立刻重新回到 open")
+	}
+}
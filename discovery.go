@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DiscoveryConfig 对应 config.json 里的 "discovery" 字段。
+// Type 为空或 "static" 时就是老行为：直接用 Config.Backends 里写死的地址。
+type DiscoveryConfig struct {
+	Type            string `json:"type"`            // "static" / "dns" / "consul"
+	IntervalSeconds int    `json:"intervalSeconds"` // 重新解析/轮询的间隔，默认 10s
+
+	// DNS SRV 模式用
+	DNSName string `json:"dnsName"` // 例如 "_http._tcp.backend.service.consul"
+
+	// Consul 模式用，直接打 Consul 的 HTTP Catalog API，不依赖额外的 SDK
+	ConsulAddr    string `json:"consulAddr"`    // 例如 "http://127.0.0.1:8500"
+	ConsulService string `json:"consulService"` // 要发现的服务名
+}
+
+// BackendFactory 把一个地址 + 权重 + 最大并发数 组装成一个可以直接丢进 ServerPool 的
+// *Backend，Director/ErrorHandler/熔断器都在这里装好，discovery 的实现完全不用关心转发细节。
+// maxConns<=0 表示跟随 Config.MaxConns 这个全局默认值。
+type BackendFactory func(rawURL string, weight int, maxConns int) (*Backend, error)
+
+// Discovery 是服务发现的统一入口。Run 应该先把"当前已知的节点"同步进 pool，
+// 然后（除了 static）另起一个 goroutine 持续监听变化，Run 本身应当立刻返回。
+type Discovery interface {
+	Run(pool *ServerPool, factory BackendFactory) error
+}
+
+// syncPeers 是三种实现共用的小工具：把 desired（本轮发现到的地址集合）跟 pool 里
+// 现有的节点做一次 diff，多了的 Add，少了的 Remove，已存在的不用动。
+func syncPeers(pool *ServerPool, factory BackendFactory, desired map[string]int, drainTimeout time.Duration) {
+	seen:=make(map[string]bool,len(desired))
+
+	for rawURL,weight:=range desired{
+		seen[rawURL]=true
+		u,err:=url.Parse(rawURL)
+		if err!=nil{
+			logger.Warn("服务发现：地址不合法，跳过", zap.String("url", rawURL))
+			continue
+		}
+
+		already:=false
+		for _,b:=range pool.All(){
+			if b.URL.String() == u.String() {
+				already=true
+				break
+			}
+		}
+		if already {
+			continue
+		}
+
+		backend,err:=factory(rawURL,weight,0)
+		if err!=nil{
+			logger.Warn("服务发现：创建节点失败", zap.String("url", rawURL), zap.Error(err))
+			continue
+		}
+		logger.Info("服务发现：新节点上线", zap.String("url", rawURL))
+		pool.Add(backend)
+	}
+
+	for _,b:=range pool.All(){
+		if !seen[b.URL.String()] {
+			logger.Info("服务发现：节点下线，开始摘除", zap.String("backend", b.URL.Host))
+			pool.Remove(b.URL, drainTimeout)
+		}
+	}
+}
+
+// ---------- 1. 静态配置（现状） ----------
+
+// StaticDiscovery 就是把 Config.Backends 里写死的地址一次性灌进 pool，不做任何监听
+type StaticDiscovery struct {
+	Backends []BackendConfig
+}
+
+func (s *StaticDiscovery) Run(pool *ServerPool, factory BackendFactory) error {
+	for _,bc:=range s.Backends{
+		backend,err:=factory(bc.URL,bc.Weight,bc.MaxConns)
+		if err!=nil{
+			logger.Warn("静态配置：解析 URL 失败", zap.String("url", bc.URL))
+			continue
+		}
+		pool.Add(backend)
+	}
+	return nil
+}
+
+// ---------- 2. DNS SRV ----------
+
+// DNSDiscovery 周期性地对一个 SRV 记录做解析，把解析出来的 host:port 同步进 pool。
+// 权重直接采用 SRV 记录里自带的 weight 字段。
+type DNSDiscovery struct {
+	Name         string
+	Interval     time.Duration
+	DrainTimeout time.Duration
+}
+
+func (d *DNSDiscovery) Run(pool *ServerPool, factory BackendFactory) error {
+	interval:=d.Interval
+	if interval <= 0 {
+		interval=10*time.Second
+	}
+
+	resolve:=func(){
+		_,srvs,err:=net.LookupSRV("","",d.Name)
+		if err!=nil{
+			logger.Warn("DNS 发现：解析失败", zap.String("name", d.Name), zap.Error(err))
+			return
+		}
+		desired:=make(map[string]int,len(srvs))
+		for _,srv:=range srvs{
+			addr:=fmt.Sprintf("http://%s:%d",trimTrailingDot(srv.Target),srv.Port)
+			weight:=int(srv.Weight)
+			if weight <= 0 {
+				weight=1
+			}
+			desired[addr]=weight
+		}
+		syncPeers(pool,factory,desired,d.DrainTimeout)
+	}
+
+	resolve() // 启动时先同步一次，Run 返回之后 balancer 已经有节点可用了
+
+	go func(){
+		for {
+			time.Sleep(interval)
+			resolve()
+		}
+	}()
+	return nil
+}
+
+func trimTrailingDot(host string) string {
+	if len(host) > 0 && host[len(host)-1] == '.' {
+		return host[:len(host)-1]
+	}
+	return host
+}
+
+// ---------- 3. Consul 服务发现 ----------
+
+// consulServiceEntry 只取 Consul Catalog API 返回里我们需要的字段
+type consulServiceEntry struct {
+	ServiceAddress string `json:"ServiceAddress"`
+	Address        string `json:"Address"`
+	ServicePort    int    `json:"ServicePort"`
+}
+
+// ConsulDiscovery 周期性地轮询 Consul 的 HTTP Catalog API（/v1/catalog/service/<name>），
+// 不依赖 consul/api SDK。一个 etcd watcher 可以用同样的 Discovery 接口实现，
+// 只是换成监听 etcd 的 key 前缀而不是轮询。
+type ConsulDiscovery struct {
+	Addr         string
+	Service      string
+	Interval     time.Duration
+	DrainTimeout time.Duration
+	client       *http.Client
+}
+
+func (c *ConsulDiscovery) Run(pool *ServerPool, factory BackendFactory) error {
+	interval:=c.Interval
+	if interval <= 0 {
+		interval=10*time.Second
+	}
+	if c.client == nil {
+		c.client=&http.Client{Timeout:5*time.Second}
+	}
+
+	poll:=func(){
+		resp,err:=c.client.Get(fmt.Sprintf("%s/v1/catalog/service/%s",c.Addr,c.Service))
+		if err!=nil{
+			logger.Warn("Consul 发现：请求失败", zap.Error(err))
+			return
+		}
+		defer resp.Body.Close()
+
+		var entries []consulServiceEntry
+		if err:=json.NewDecoder(resp.Body).Decode(&entries);err!=nil{
+			logger.Warn("Consul 发现：解析响应失败", zap.Error(err))
+			return
+		}
+
+		desired:=make(map[string]int,len(entries))
+		for _,e:=range entries{
+			host:=e.ServiceAddress
+			if host == "" {
+				host=e.Address
+			}
+			desired[fmt.Sprintf("http://%s:%d",host,e.ServicePort)]=1
+		}
+		syncPeers(pool,factory,desired,c.DrainTimeout)
+	}
+
+	poll()
+
+	go func(){
+		for {
+			time.Sleep(interval)
+			poll()
+		}
+	}()
+	return nil
+}
+
+// newBackendFactory 构造一个 BackendFactory：把 Director/ErrorHandler 的装配逻辑
+// 从 main() 里搬出来，这样静态配置和服务发现可以共用同一份"如何造一个 Backend"的逻辑。
+// store 在每次真正造节点的时候才 Load()，这样新节点总是按"当前"配置（而不是
+// newBackendFactory 被调用那一刻的配置）拿到 MaxConns/CircuitBreaker 默认值。
+// pool 传给 ErrorHandler 用来判断重试耗尽时是"真没节点了"还是"全被熔断器短路了"。
+func newBackendFactory(balancer Balancer, pool *ServerPool, store *ConfigStore) BackendFactory {
+	return func(rawURL string, weight int, maxConns int) (*Backend, error) {
+		u,err:=url.Parse(rawURL)
+		if err!=nil{
+			return nil, err
+		}
+		cfg:=store.Load()
+
+		proxy:=httputil.NewSingleHostReverseProxy(u)
+		proxy.Director=func(req *http.Request){
+			req.Host=u.Host
+			req.URL.Host=u.Host
+			req.URL.Scheme=u.Scheme
+		}
+
+		if weight <= 0 {
+			weight=1
+		}
+		if maxConns <= 0 {
+			maxConns=cfg.MaxConns
+		}
+
+		node:=&Backend{
+			URL:      u,
+			ReverseProxy: proxy,
+			Breaker:  NewCircuitBreaker(cfg.CircuitBreaker),
+		}
+		node.Alive.Store(true)
+		node.Weight.Store(int32(weight))
+		node.MaxConns.Store(int32(maxConns))
+		node.ReverseProxy.ErrorHandler=makeErrorHandler(node,balancer,pool,store)
+		node.ReverseProxy.ModifyResponse=func(resp *http.Response) error {
+			if resp.StatusCode >= 500 {
+				node.Breaker.RecordFailure()
+			} else {
+				node.Breaker.RecordSuccess()
+			}
+			return nil
+		}
+		return node, nil
+	}
+}
+
+// newDiscovery 根据 Config.Discovery.Type 造出对应的 Discovery 实现，
+// 不认识的类型（或者没配置）一律退化成 static，保证老配置不用改就能跑
+func newDiscovery(cfg *Config) Discovery {
+	switch cfg.Discovery.Type {
+	case "dns":
+		return &DNSDiscovery{
+			Name:         cfg.Discovery.DNSName,
+			Interval:     time.Duration(cfg.Discovery.IntervalSeconds)*time.Second,
+			DrainTimeout: drainTimeout(cfg),
+		}
+	case "consul":
+		return &ConsulDiscovery{
+			Addr:         cfg.Discovery.ConsulAddr,
+			Service:      cfg.Discovery.ConsulService,
+			Interval:     time.Duration(cfg.Discovery.IntervalSeconds)*time.Second,
+			DrainTimeout: drainTimeout(cfg),
+		}
+	case "static","":
+		return &StaticDiscovery{Backends: cfg.Backends}
+	default:
+		return &StaticDiscovery{Backends: cfg.Backends}
+	}
+}
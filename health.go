@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HealthCheckConfig 是 config.json 里 "healthCheck" 字段对应的配置。
+// 不填的话就是原来的行为：TCP 拨号探活，2 秒一次，一次成功/失败就直接翻转状态。
+type HealthCheckConfig struct {
+	Mode               string `json:"mode"`               // "tcp" (默认) 或 "http"
+	Path               string `json:"path"`                // http 模式下要 GET 的路径，比如 "/healthz"
+	IntervalSeconds    int    `json:"intervalSeconds"`    // 探测间隔，默认 2
+	TimeoutSeconds     int    `json:"timeoutSeconds"`     // 单次探测超时，默认 2
+	HealthyThreshold   int    `json:"healthyThreshold"`   // 连续几次成功才判定为"活"，默认 1
+	UnhealthyThreshold int    `json:"unhealthyThreshold"` // 连续几次失败才判定为"挂"，默认 1
+}
+
+// withDefaults 把没填的字段补上默认值，避免到处判断零值
+func (h HealthCheckConfig) withDefaults() HealthCheckConfig {
+	if h.IntervalSeconds <= 0 {
+		h.IntervalSeconds = 2
+	}
+	if h.TimeoutSeconds <= 0 {
+		h.TimeoutSeconds = 2
+	}
+	if h.HealthyThreshold <= 0 {
+		h.HealthyThreshold = 1
+	}
+	if h.UnhealthyThreshold <= 0 {
+		h.UnhealthyThreshold = 1
+	}
+	return h
+}
+
+// healthState 记录每个节点最近一次健康检查的"连续次数"和时间戳，单独加锁保护，
+// 因为健康检查协程在写、/lb/status 接口在读，两边是并发的。
+type healthState struct {
+	mu              sync.Mutex
+	consecutivePass int
+	consecutiveFail int
+	lastCheck       time.Time
+}
+
+// recordCheck 按照配置的阈值做"debounce"：只有连续 N 次成功/失败才真的翻转 Alive，
+// 这样网络抖动导致的偶尔一次失败不会让一个健康的节点被误摘掉。
+func (b *Backend) recordCheck(ok bool, cfg HealthCheckConfig) {
+	b.health.mu.Lock()
+	defer b.health.mu.Unlock()
+
+	b.health.lastCheck = time.Now()
+	if ok {
+		b.health.consecutivePass++
+		b.health.consecutiveFail = 0
+		if b.health.consecutivePass >= cfg.HealthyThreshold {
+			b.Alive.Store(true)
+		}
+	} else {
+		b.health.consecutiveFail++
+		b.health.consecutivePass = 0
+		if b.health.consecutiveFail >= cfg.UnhealthyThreshold {
+			b.Alive.Store(false)
+		}
+	}
+}
+
+// healthSnapshot 是 /lb/status 返回的单个节点快照
+type healthSnapshot struct {
+	URL             string    `json:"url"`
+	Alive           bool      `json:"alive"`
+	Draining        bool      `json:"draining"`
+	ConsecutivePass int       `json:"consecutivePass"`
+	ConsecutiveFail int       `json:"consecutiveFail"`
+	LastCheck       time.Time `json:"lastCheck"`
+}
+
+func (b *Backend) snapshot() healthSnapshot {
+	b.health.mu.Lock()
+	defer b.health.mu.Unlock()
+
+	return healthSnapshot{
+		URL:             b.URL.String(),
+		Alive:           b.Alive.Load(),
+		Draining:        b.Draining,
+		ConsecutivePass: b.health.consecutivePass,
+		ConsecutiveFail: b.health.consecutiveFail,
+		LastCheck:       b.health.lastCheck,
+	}
+}
+
+// HealthChecker 给 pool 里的每个节点各开一个独立的 goroutine 做探活，
+// 而不是像以前那样用一个 for 循环串行地挨个检查（节点一多，串行检查会拖慢整体探测周期）。
+type HealthChecker struct {
+	cfg     HealthCheckConfig
+	client  *http.Client
+	mu      sync.Mutex
+	started map[*Backend]bool
+}
+
+func NewHealthChecker(cfg HealthCheckConfig) *HealthChecker {
+	cfg = cfg.withDefaults()
+	return &HealthChecker{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+		started: make(map[*Backend]bool),
+	}
+}
+
+// Watch 持续扫描 pool，给每个还没开检查协程的节点（包括服务发现后来加进来的）都配一个
+func (hc *HealthChecker) Watch(pool *ServerPool) {
+	go func() {
+		for {
+			for _, b := range pool.All() {
+				hc.ensureStarted(b)
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+}
+
+func (hc *HealthChecker) ensureStarted(b *Backend) {
+	hc.mu.Lock()
+	if hc.started[b] {
+		hc.mu.Unlock()
+		return
+	}
+	hc.started[b] = true
+	hc.mu.Unlock()
+
+	go hc.runFor(b)
+}
+
+func (hc *HealthChecker) runFor(b *Backend) {
+	interval := time.Duration(hc.cfg.IntervalSeconds) * time.Second
+	for {
+		ok := hc.probe(b)
+		before := b.Alive.Load()
+		b.recordCheck(ok, hc.cfg)
+		if after := b.Alive.Load(); before != after {
+			setBackendUp(b.URL.Host, after)
+			if after {
+				logger.Info("健康检查：节点复活了", zap.String("backend", b.URL.Host))
+			} else {
+				logger.Warn("健康检查：节点挂了", zap.String("backend", b.URL.Host))
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// probe 根据配置的模式探测一次：http 模式 GET HealthPath 看是否 2xx，否则退回 TCP 拨号
+func (hc *HealthChecker) probe(b *Backend) bool {
+	if hc.cfg.Mode == "http" {
+		path := hc.cfg.Path
+		if path == "" {
+			path = "/"
+		}
+		resp, err := hc.client.Get(b.URL.String() + path)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode >= 200 && resp.StatusCode < 300
+	}
+	conn, err := net.DialTimeout("tcp", b.URL.Host, hc.client.Timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
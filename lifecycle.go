@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BalancerHolder 让"当前用哪个负载均衡策略"变成可以热替换的。
+// main() 里只拿到一个 *BalancerHolder（它自己实现了 Balancer 接口），
+// SIGHUP 重新加载配置发现 strategy 变了的时候，直接 Set 一个新的 Balancer 进去，
+// 所有已经持有这个 holder 的地方（路由 handler、每个节点的 ErrorHandler）立刻生效，
+// 不用重启监听、也不用挨个去改已经建好的闭包。
+type BalancerHolder struct {
+	mu sync.RWMutex
+	b  Balancer
+}
+
+func NewBalancerHolder(initial Balancer) *BalancerHolder {
+	return &BalancerHolder{b: initial}
+}
+
+func (h *BalancerHolder) NextPeer(req *http.Request) *Backend {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.b.NextPeer(req)
+}
+
+func (h *BalancerHolder) Set(b Balancer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.b = b
+}
+
+// ConfigStore 让"当前生效的配置"可以被安全地整体替换。SIGHUP 热重载以前是直接
+// *live = *newCfg 做字段级原地拷贝，这个写操作和每个请求的 goroutine 并发读
+// cfg.MaxAttempts/cfg.MaxRetries 之类的字段之间没有任何同步，是一个真实的数据
+// 竞争。换成 atomic.Pointer 之后，重载时整个新建一个 *Config 再原子地换上去，
+// 所有读者只要用 Load() 而不是长期攥着某一次 Load() 的结果，就永远读到一份完整
+// 一致的配置快照，不会读到"换了一半"的 Config。
+type ConfigStore struct {
+	p atomic.Pointer[Config]
+}
+
+func NewConfigStore(initial *Config) *ConfigStore {
+	s := &ConfigStore{}
+	s.p.Store(initial)
+	return s
+}
+
+func (s *ConfigStore) Load() *Config {
+	return s.p.Load()
+}
+
+func (s *ConfigStore) Store(cfg *Config) {
+	s.p.Store(cfg)
+}
+
+// totalActiveConns 把 pool 里每个节点的 ActiveConns 加起来，优雅关机时用来判断
+// "还有没有请求没处理完"
+func totalActiveConns(pool *ServerPool) int64 {
+	var total int64
+	for _, b := range pool.All() {
+		total += atomic.LoadInt64(&b.ActiveConns)
+	}
+	return total
+}
+
+// waitForDrain 轮询等 pool 里所有节点的 ActiveConns 归零，最多等 timeout，
+// 用在优雅关机流程里，给 http.Server.Shutdown 之外再上一道保险
+func waitForDrain(pool *ServerPool, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if totalActiveConns(pool) == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// reloadConfig 响应 SIGHUP：重新读一遍 config.json，跟当前活着的 pool 做 diff，
+// 新增的节点建好 Backend 加进去，消失的节点走正常的 Remove（排空）流程摘掉，
+// 还在的节点原地更新权重/并发数上限，strategy 变了就把新的 Balancer 塞进 holder。
+// store 是 main() 里一直在用的 *ConfigStore，其余没有特殊处理的字段随着最后的
+// store.Store(newCfg) 一起整体换新：makeErrorHandler 等地方都是每次用的时候
+// 现读 store.Load()，不会拿着旧 Config 不放。
+func reloadConfig(path string, store *ConfigStore, pool *ServerPool, factory BackendFactory, holder *BalancerHolder) error {
+	live := store.Load()
+	newCfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if newCfg.MaxAttempts <= 0 {
+		newCfg.MaxAttempts = 1
+	}
+
+	desired := make(map[string]BackendConfig)
+	for _, bc := range newCfg.Backends {
+		u, err := url.Parse(bc.URL)
+		if err != nil {
+			logger.Warn("热重载：地址不合法，跳过", zap.String("url", bc.URL))
+			continue
+		}
+		desired[u.String()] = bc
+	}
+
+	for _, b := range pool.All() {
+		key := b.URL.String()
+		bc, stillWanted := desired[key]
+		if !stillWanted {
+			logger.Info("热重载：配置里已经没有这个节点了，开始摘除", zap.String("backend", b.URL.Host))
+			pool.Remove(b.URL, drainTimeout(newCfg))
+			continue
+		}
+
+		weight := bc.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		maxConns := bc.MaxConns
+		if maxConns <= 0 {
+			maxConns = newCfg.MaxConns
+		}
+		b.Weight.Store(int32(weight))
+		b.MaxConns.Store(int32(maxConns))
+		delete(desired, key) // 剩下的就是真正的新节点
+	}
+
+	for _, bc := range desired {
+		node, err := factory(bc.URL, bc.Weight, bc.MaxConns)
+		if err != nil {
+			logger.Warn("热重载：创建节点失败", zap.String("url", bc.URL), zap.Error(err))
+			continue
+		}
+		logger.Info("热重载：新节点上线", zap.String("url", bc.URL))
+		pool.Add(node)
+	}
+
+	if newCfg.Strategy != live.Strategy {
+		logger.Info("热重载：负载均衡策略切换", zap.String("from", live.Strategy), zap.String("to", newCfg.Strategy))
+		holder.Set(NewBalancer(newCfg.Strategy, pool))
+	}
+
+	store.Store(newCfg)
+	return nil
+}
+
+// gracefulShutdown 等待 ctx 被取消（SIGINT/SIGTERM），然后在 graceSeconds 之内
+// 关闭 http.Server（停止接收新连接、等现有连接处理完）并确认所有后端的 ActiveConns 归零
+func gracefulShutdown(ctx context.Context, srv *http.Server, pool *ServerPool, graceSeconds int) {
+	<-ctx.Done()
+	logger.Info("优雅关机：收到退出信号，开始排空...")
+
+	grace := time.Duration(graceSeconds) * time.Second
+	if grace <= 0 {
+		grace = 15 * time.Second
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("优雅关机：Shutdown 超时/出错", zap.Error(err))
+	}
+
+	// Shutdown 本身已经会等所有正在处理的请求跑完，这里再确认一遍 ActiveConns，
+	// 防止有请求卡在转发给后端的路上（比如后端响应很慢）没被算进去；
+	// 只用 grace 里剩下的时间，整个关机过程不超过 grace
+	deadline, _ := shutdownCtx.Deadline()
+	waitForDrain(pool, time.Until(deadline))
+	logger.Info("优雅关机：排空完成，进程退出")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 所有指标都用 promauto 注册到默认 Registry，/metrics 直接用 promhttp 暴露出去。
+// 这几个指标只在请求前后各摸一下原子计数器/调用一次 Observe，不会卡在热路径上。
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lb_requests_total",
+		Help: "转发给每个后端的请求总数，按 backend 和响应状态码分类",
+	}, []string{"backend", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "lb_request_duration_seconds",
+		Help: "请求从进入到转发结束的耗时，按 backend 分类",
+	}, []string{"backend"})
+
+	activeConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lb_active_connections",
+		Help: "每个后端当前正在处理的请求数",
+	}, []string{"backend"})
+
+	backendUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lb_backend_up",
+		Help: "后端健康状态，1 表示存活，0 表示挂了",
+	}, []string{"backend"})
+
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lb_retries_total",
+		Help: "因为转发失败而换节点重试的次数，按最初失败的 backend 分类",
+	}, []string{"backend"})
+)
+
+// setBackendUp 把健康状态同步进 Prometheus gauge，health.go 在状态翻转时调用
+func setBackendUp(host string, alive bool) {
+	if alive {
+		backendUp.WithLabelValues(host).Set(1)
+	} else {
+		backendUp.WithLabelValues(host).Set(0)
+	}
+}
+
+// metricsHandler 是挂在 /metrics 上的标准 Prometheus 导出 handler
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
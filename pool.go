@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainCheckInterval 是 Remove 等待 ActiveConns 归零时，轮询检查的间隔
+const drainCheckInterval = 100 * time.Millisecond
+
+// drainTimeout 读取配置里的排空超时，没填就是 30 秒
+func drainTimeout(cfg *Config) time.Duration {
+	if cfg.DrainTimeoutSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(cfg.DrainTimeoutSeconds) * time.Second
+}
+
+// ServerPool 统一管理"当前有哪些后端节点"，取代过去那个写死在 main 里的 nodes 切片。
+// 有了它之后，服务发现（DNS/Consul 等）就能在运行时动态地增删节点，
+// 而不需要重启进程或者直接碰裸切片。
+type ServerPool struct {
+	mu       sync.RWMutex
+	backends []*Backend
+}
+
+// NewServerPool 创建一个空的节点池，后续通过 Add 往里面加节点
+func NewServerPool() *ServerPool {
+	return &ServerPool{}
+}
+
+// Add 把一个新节点加入池子。如果这个 URL 已经存在，就直接跳过（避免重复发现同一个节点）
+func (p *ServerPool) Add(b *Backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _,existing:=range p.backends{
+		if existing.URL.String() == b.URL.String() {
+			return // 已经有了，不重复添加
+		}
+	}
+	p.backends=append(p.backends,b)
+}
+
+// Remove 把节点从池子里摘除。摘除不是立刻生效的硬删除：
+// 先把它标记为 Draining，balancer 就不会再把新请求转发给它了；
+// 然后后台等它的 ActiveConns 归零（或者等到 drainTimeout 超时），再真正从切片里删掉。
+func (p *ServerPool) Remove(u *url.URL, drainTimeout time.Duration) {
+	p.mu.Lock()
+	var target *Backend
+	for _,b:=range p.backends{
+		if b.URL.String() == u.String() {
+			target=b
+			b.Draining=true
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	if target == nil {
+		return
+	}
+
+	go func(){
+		deadline:=time.Now().Add(drainTimeout)
+		for time.Now().Before(deadline) {
+			if atomic.LoadInt64(&target.ActiveConns) == 0 {
+				break
+			}
+			time.Sleep(drainCheckInterval)
+		}
+		p.removeExact(target)
+	}()
+}
+
+// removeExact 把某个具体的 *Backend 从切片里删掉（不再关心 draining 状态，直接摘）
+func (p *ServerPool) removeExact(target *Backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i,b:=range p.backends{
+		if b == target {
+			p.backends=append(p.backends[:i],p.backends[i+1:]...)
+			return
+		}
+	}
+}
+
+// MarkStatus 直接把某个节点的存活状态设成 alive，供 /lb/mark 这类需要手动干预状态
+// 的场景使用。健康检查的读写（主动探测 + 被动失败）都走 Backend.recordCheck，不经过
+// 这里，因为 recordCheck 会做 HealthyThreshold/UnhealthyThreshold 的连续计数防抖，
+// 手动干预则是运维明确要求立刻生效，不用等阈值。
+func (p *ServerPool) MarkStatus(u *url.URL, alive bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _,b:=range p.backends{
+		if b.URL.String() == u.String() {
+			b.Alive.Store(alive)
+			return
+		}
+	}
+}
+
+// Peers 返回当前可以接收新请求的节点快照（正在 draining 的节点不算在内）。
+// 返回的是切片的拷贝，调用方可以放心遍历，不用担心并发修改。
+func (p *ServerPool) Peers() []*Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	peers:=make([]*Backend,0,len(p.backends))
+	for _,b:=range p.backends{
+		if !b.Draining {
+			peers=append(peers,b)
+		}
+	}
+	return peers
+}
+
+// All 返回池子里的全部节点，包括正在 draining 的（健康检查协程要用，draining 的节点也得继续探活）
+func (p *ServerPool) All() []*Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	all:=make([]*Backend,len(p.backends))
+	copy(all,p.backends)
+	return all
+}
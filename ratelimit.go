@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig 对应 config.json 里的 "rateLimit" 字段。RPS<=0 表示不限流（默认）。
+type RateLimitConfig struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// IPRateLimiter 给每个来源 IP 各配一个令牌桶，桶用到的时候才懒加载创建
+type IPRateLimiter struct {
+	mu    sync.Mutex
+	rps   rate.Limit
+	burst int
+	byIP  map[string]*rate.Limiter
+}
+
+func NewIPRateLimiter(cfg RateLimitConfig) *IPRateLimiter {
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	return &IPRateLimiter{
+		rps:   rate.Limit(cfg.RPS),
+		burst: burst,
+		byIP:  make(map[string]*rate.Limiter),
+	}
+}
+
+func (l *IPRateLimiter) limiterFor(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lim, ok := l.byIP[ip]
+	if !ok {
+		lim = rate.NewLimiter(l.rps, l.burst)
+		l.byIP[ip] = lim
+	}
+	return lim
+}
+
+// rateLimitMiddleware 按客户端 IP 做令牌桶限流，RPS<=0 时直接放行（功能关闭）
+func rateLimitMiddleware(cfg RateLimitConfig) gin.HandlerFunc {
+	if cfg.RPS <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	limiter := NewIPRateLimiter(cfg)
+	return func(c *gin.Context) {
+		if !limiter.limiterFor(c.ClientIP()).Allow() {
+			c.String(http.StatusTooManyRequests, "请求太快了，歇一下")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// 这几个 key 专门用来在 context 里传递"重试状态"，用自定义类型防止和别的包的 key 撞车
+type retryCtxKey string
+
+const (
+	ctxKeyAttempts retryCtxKey = "attempts" // 当前这个节点已经被转发过几次（含本次）
+	ctxKeyRetries  retryCtxKey = "retries"  // 已经换过几个节点重试了
+	ctxKeyBody     retryCtxKey = "body"     // 缓存好的请求体，nil 表示这个请求不能重放
+	ctxKeyCanRetry retryCtxKey = "canRetry" // 这个请求是否有资格重试（幂等 & 体积没超限）
+)
+
+// maxBufferedBody 限制允许被缓存重放的请求体大小，超过这个体积的请求
+// 一旦失败就不重试了，避免为了重试把整个大文件反复塞进内存
+const maxBufferedBody = 10 << 20 // 10MB
+
+func attemptsFrom(ctx context.Context) int {
+	if v,ok:=ctx.Value(ctxKeyAttempts).(int);ok{
+		return v
+	}
+	return 0
+}
+
+// retries 用一个共享的 *int32 存进 context，而不是每次重试都 WithValue 一个新的 int：
+// httputil.ReverseProxy 重试时操作的是克隆出来的新 *http.Request，gin 手里的 c.Request
+// 永远指向最初那一个，它的 context 不会被后面重试时产生的新 context 替换掉。
+// 如果存的是普通 int，c.Request.Context() 读到的永远是最初写进去的那个值（0）；
+// 存指针的话，所有派生 context 和最初的 context 都指向同一个 *int32，
+// 在任意一次重试里自增，最初的 c.Request.Context() 也能读到最新的值。
+func retriesFrom(ctx context.Context) int {
+	if p,ok:=ctx.Value(ctxKeyRetries).(*int32);ok{
+		return int(atomic.LoadInt32(p))
+	}
+	return 0
+}
+
+// incRetries 把 context 里共享的重试计数 +1，返回自增后的值
+func incRetries(ctx context.Context) int {
+	p,ok:=ctx.Value(ctxKeyRetries).(*int32)
+	if !ok{
+		return 0
+	}
+	return int(atomic.AddInt32(p,1))
+}
+
+// bufferRequestBody 把请求体读出来缓存成 []byte，方便失败之后重新塞回 req.Body 重放。
+// 非幂等方法（除了 GET/HEAD/OPTIONS）或者体积超过 maxBufferedBody 的请求不缓存，
+// ok=false 表示这个请求没有资格重试，失败了就只能老老实实返回 502。
+func bufferRequestBody(req *http.Request) (body []byte, ok bool) {
+	idempotent:=req.Method==http.MethodGet || req.Method==http.MethodHead || req.Method==http.MethodOptions
+	if !idempotent {
+		return nil, false
+	}
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+
+	data,err:=io.ReadAll(io.LimitReader(req.Body, maxBufferedBody+1))
+	if err!=nil || len(data) > maxBufferedBody {
+		// 体积超限（或者读取出错）不代表这个请求就能被丢弃——原始请求后面还得照常
+		// 转发下去。此时 req.Body 只读出来了一部分，不能直接 Close，得用 MultiReader
+		// 把已经读出来的这部分和还没读到的剩余部分重新拼回去，相当于 req.Body 完全没被动过。
+		req.Body=io.NopCloser(io.MultiReader(bytes.NewReader(data), req.Body))
+		return nil, false
+	}
+
+	req.Body.Close()
+	req.Body=io.NopCloser(bytes.NewReader(data))
+	return data, true
+}
+
+// rewindBody 把缓存好的请求体重新塞回 req，好让下一次转发能读到完整的 body
+func rewindBody(req *http.Request, body []byte) {
+	if body == nil {
+		return
+	}
+	req.Body=io.NopCloser(bytes.NewReader(body))
+	req.ContentLength=int64(len(body))
+}
+
+// prepareRetry 在请求第一次进入 balancer 之前调用，把"能不能重试"和缓存好的 body
+// 塞进 context，这样不管后面重试多少次、落到哪个节点的 ErrorHandler 上，都能读到同一份信息。
+func prepareRetry(req *http.Request) *http.Request {
+	body,canRetry:=bufferRequestBody(req)
+	ctx:=context.WithValue(req.Context(), ctxKeyBody, body)
+	ctx=context.WithValue(ctx, ctxKeyCanRetry, canRetry)
+	ctx=context.WithValue(ctx, ctxKeyAttempts, 1)
+	ctx=context.WithValue(ctx, ctxKeyRetries, new(int32))
+	return req.WithContext(ctx)
+}
+
+// makeErrorHandler 给某个 Backend 的 ReverseProxy 生成 ErrorHandler，所有打到这个节点的
+// 请求共用同一个 ErrorHandler，具体这一次请求能不能重试要从 context 里读。流程：
+//  1. 给这个节点记一次失败（被动健康检查），连续失败达到 UnhealthyThreshold 才标记为不存活，
+//     之后的健康检查会尝试把它捞回来
+//  2. 如果这个请求的 body 不能重放，直接 502
+//  3. MaxAttempts 是"同一个节点最多打几次"：没用完就原地再打一次这个节点，不换节点
+//  4. 同一个节点的 attempts 用完了，才算一次跨节点重试：MaxRetries 用完了也没有更换节点
+//     的机会了，直接 502；否则回到 balancer 里挑下一个存活节点，attempts 清零重新计
+//  5. 最终拿不到下一个节点时，如果是因为熔断器把所有存活节点都短路了，返回 503 而不是 502，
+//     这样调用方能分清"后端真的都挂了"还是"只是暂时被熔断，等会儿再试"
+func makeErrorHandler(node *Backend, balancer Balancer, pool *ServerPool, store *ConfigStore) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		cfg:=store.Load()
+		logger.Warn("backend proxy error", zap.String("backend",node.URL.Host), zap.Error(err))
+
+		// 被动健康检查：走 recordCheck 而不是直接改 node.Alive，这样才能跟主动健康检查
+		// 共用同一套 UnhealthyThreshold 连续失败计数和同一把 b.health.mu，不然一次转发失败
+		// 就立刻把节点打挂，跟阈值判定的主动检查互相打架
+		wasAlive:=node.Alive.Load()
+		node.recordCheck(false, cfg.HealthCheck.withDefaults())
+		if isAlive:=node.Alive.Load(); wasAlive != isAlive {
+			setBackendUp(node.URL.Host,isAlive)
+		}
+		node.Breaker.RecordFailure()
+
+		ctx:=r.Context()
+		canRetry,_:=ctx.Value(ctxKeyCanRetry).(bool)
+		if !canRetry {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("服务器挂了，正在抢救..."))
+			return
+		}
+
+		body,_:=ctx.Value(ctxKeyBody).([]byte)
+		attempts:=attemptsFrom(ctx)
+
+		if attempts < cfg.MaxAttempts {
+			rewindBody(r, body)
+			ctx=context.WithValue(ctx, ctxKeyAttempts, attempts+1)
+			r=r.WithContext(ctx)
+
+			logger.Info("retrying same backend", zap.String("backend",node.URL.Host), zap.Int("attempt",attempts+1))
+			node.ReverseProxy.ServeHTTP(w, r)
+			return
+		}
+
+		retries:=retriesFrom(ctx)
+		if retries >= cfg.MaxRetries {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("服务器挂了，正在抢救..."))
+			return
+		}
+
+		next:=balancer.NextPeer(r)
+		if next == nil {
+			if allBreakerOpen(pool.Peers()) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("后端暂时被熔断，请稍后重试"))
+			} else {
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte("服务器全军覆没"))
+			}
+			return
+		}
+
+		rewindBody(r, body)
+		ctx=context.WithValue(ctx, ctxKeyAttempts, 1)
+		newRetries:=incRetries(ctx)
+		r=r.WithContext(ctx)
+
+		logger.Info("retrying on next backend", zap.String("from",node.URL.Host), zap.String("to",next.URL.Host), zap.Int("retry",newRetries))
+		next.ReverseProxy.ServeHTTP(w, r)
+	}
+}